@@ -0,0 +1,169 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// damerauLevenshtein computes the Damerau-Levenshtein edit distance
+// between two generic sequences, allowing insertions, deletions,
+// substitutions, and transpositions of adjacent elements. It works over
+// []rune or []byte alike
+func damerauLevenshtein[T comparable](a, b []T) int {
+	la, lb := len(a), len(b)
+	d := make([][]int, la+1)
+	for i := range d {
+		d[i] = make([]int, lb+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= lb; j++ {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= la; i++ {
+		for j := 1; j <= lb; j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if transposed := d[i-2][j-2] + cost; transposed < d[i][j] {
+					d[i][j] = transposed
+				}
+			}
+		}
+	}
+
+	return d[la][lb]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// jaroSimilarity computes the Jaro similarity of two generic sequences,
+// in [0, 1]
+func jaroSimilarity[T comparable](a, b []T) float64 {
+	la, lb := len(a), len(b)
+	if la == 0 && lb == 0 {
+		return 1
+	}
+	if la == 0 || lb == 0 {
+		return 0
+	}
+
+	matchDistance := la
+	if lb > matchDistance {
+		matchDistance = lb
+	}
+	matchDistance = matchDistance/2 - 1
+	if matchDistance < 0 {
+		matchDistance = 0
+	}
+
+	aMatches, bMatches := make([]bool, la), make([]bool, lb)
+	matches := 0
+	for i := 0; i < la; i++ {
+		start, end := i-matchDistance, i+matchDistance+1
+		if start < 0 {
+			start = 0
+		}
+		if end > lb {
+			end = lb
+		}
+		for j := start; j < end; j++ {
+			if bMatches[j] || a[i] != b[j] {
+				continue
+			}
+			aMatches[i], bMatches[j] = true, true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, k := 0, 0
+	for i := 0; i < la; i++ {
+		if !aMatches[i] {
+			continue
+		}
+		for !bMatches[k] {
+			k++
+		}
+		if a[i] != b[k] {
+			transpositions++
+		}
+		k++
+	}
+
+	m := float64(matches)
+	return (m/float64(la) + m/float64(lb) + (m-float64(transpositions)/2)/m) / 3
+}
+
+// jaroWinklerPrefixWeight is the standard Jaro-Winkler scaling factor
+const jaroWinklerPrefixWeight = 0.1
+
+// jaroWinklerMaxPrefix is the maximum common prefix length that boosts
+// the Jaro score
+const jaroWinklerMaxPrefix = 4
+
+// jaroWinklerSimilarity computes the Jaro-Winkler similarity of two
+// generic sequences, boosting jaroSimilarity for a shared prefix
+func jaroWinklerSimilarity[T comparable](a, b []T) float64 {
+	jaro := jaroSimilarity(a, b)
+
+	prefix := 0
+	for prefix < jaroWinklerMaxPrefix && prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	return jaro + float64(prefix)*jaroWinklerPrefixWeight*(1-jaro)
+}
+
+// FuzzyLookup finds keys in v.Words within maxDistance Damerau-Levenshtein
+// edits of word, so a query can hit the underlying vectors despite
+// misspellings or morphological variants
+func (v *Vectors) FuzzyLookup(word string, maxDistance int) []string {
+	target := []rune(word)
+	var candidates []string
+	for key := range v.Words {
+		if damerauLevenshtein(target, []rune(key)) <= maxDistance {
+			candidates = append(candidates, key)
+		}
+	}
+	return candidates
+}
+
+// FuzzyLookupSimilarity finds keys in v.Words whose Jaro-Winkler
+// similarity to word is at least minSimilarity
+func (v *Vectors) FuzzyLookupSimilarity(word string, minSimilarity float64) []string {
+	target := []rune(word)
+	var candidates []string
+	for key := range v.Words {
+		if jaroWinklerSimilarity(target, []rune(key)) >= minSimilarity {
+			candidates = append(candidates, key)
+		}
+	}
+	return candidates
+}
+
+// FuzzyVector sums the word vectors of every key within maxDistance
+// Damerau-Levenshtein edits of word, letting a misspelled or unseen query
+// still land on the dense vectors of its near-matches
+func (v *Vectors) FuzzyVector(word string, maxDistance int) []int64 {
+	vector := make([]int64, vectorSize)
+	for _, candidate := range v.FuzzyLookup(word, maxDistance) {
+		for i, element := range v.Words[candidate] {
+			vector[i] += element
+		}
+	}
+	return vector
+}
@@ -0,0 +1,391 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const manifestName = "manifest.json"
+
+// manifest tracks the segments that make up an Index, in the order they
+// were flushed
+type manifest struct {
+	Segments []string `json:"segments"`
+}
+
+// Index is a persistent, incrementally updatable store for a Vectors
+// aggregate. Documents are buffered in memory and written out as
+// immutable segments, one per Flush, mirroring the segment-and-merge
+// layout of a scorch-style index
+type Index struct {
+	dir      string
+	manifest manifest
+	// Vectors is the merged view of every segment on disk plus anything
+	// added since the last Flush
+	Vectors *Vectors
+	pending *Vectors
+}
+
+// Open opens or creates an Index rooted at dir, loading and merging
+// whatever segments are already present
+func Open(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		dir:     dir,
+		Vectors: NewVectors(),
+		pending: NewVectors(),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &idx.manifest); err != nil {
+		return nil, err
+	}
+
+	for _, name := range idx.manifest.Segments {
+		segment, err := loadSegment(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		mergeSegment(idx.Vectors, segment)
+	}
+
+	return idx, nil
+}
+
+// AddDocument stages a document's BigVector, making it visible in
+// idx.Vectors immediately and queued for the next Flush
+func (idx *Index) AddDocument(vector *BigVector) {
+	idx.Vectors.Merge(vector)
+	idx.pending.Merge(vector)
+}
+
+// Flush writes everything staged since the last Flush as a new segment
+// and records it in the manifest
+func (idx *Index) Flush() error {
+	if len(idx.pending.Documents) == 0 && len(idx.pending.Words) == 0 {
+		return nil
+	}
+
+	name := idx.nextSegmentName()
+	if err := writeSegment(filepath.Join(idx.dir, name), idx.pending); err != nil {
+		return err
+	}
+
+	idx.manifest.Segments = append(idx.manifest.Segments, name)
+	if err := idx.saveManifest(); err != nil {
+		return err
+	}
+
+	idx.pending = NewVectors()
+	return nil
+}
+
+// Merge compacts every segment on disk into a single segment, summing
+// word-vector histograms across them
+func (idx *Index) Merge() error {
+	if err := idx.Flush(); err != nil {
+		return err
+	}
+	if len(idx.manifest.Segments) <= 1 {
+		return nil
+	}
+
+	merged := NewVectors()
+	for _, name := range idx.manifest.Segments {
+		segment, err := loadSegment(filepath.Join(idx.dir, name))
+		if err != nil {
+			return err
+		}
+		mergeSegment(merged, segment)
+	}
+
+	mergedName := idx.nextSegmentName()
+	if err := writeSegment(filepath.Join(idx.dir, mergedName), merged); err != nil {
+		return err
+	}
+
+	old := idx.manifest.Segments
+	idx.manifest.Segments = []string{mergedName}
+	if err := idx.saveManifest(); err != nil {
+		return err
+	}
+	for _, name := range old {
+		os.Remove(filepath.Join(idx.dir, name))
+	}
+
+	return nil
+}
+
+// nextSegmentName returns the first segment file name, counting up from
+// the number of segments in the manifest, that is not already on disk
+func (idx *Index) nextSegmentName() string {
+	for n := len(idx.manifest.Segments); ; n++ {
+		name := fmt.Sprintf("segment-%05d.bin", n)
+		if _, err := os.Stat(filepath.Join(idx.dir, name)); err != nil {
+			return name
+		}
+	}
+}
+
+func (idx *Index) saveManifest() error {
+	data, err := json.Marshal(idx.manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(idx.dir, manifestName), data, 0644)
+}
+
+// Load opens the index at dir and returns its fully merged Vectors
+func Load(dir string) (*Vectors, error) {
+	idx, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Vectors, nil
+}
+
+// mergeSegment folds a segment into dst, summing word vectors and
+// letting later documents win on name collisions
+func mergeSegment(dst, segment *Vectors) {
+	for name, vector := range segment.Documents {
+		dst.Documents[name] = vector
+	}
+	for word, vector := range segment.Words {
+		wordVector := dst.Words[word]
+		if wordVector == nil {
+			wordVector = make([]int64, len(vector))
+			dst.Words[word] = wordVector
+		}
+		for i, element := range vector {
+			wordVector[i] += element
+		}
+	}
+}
+
+// writeSegment serializes a Vectors batch to path using a compact binary
+// encoding: raw varints for dense vectors, varint-delta indices for
+// sparse ones
+func writeSegment(path string, segment *Vectors) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	if err := writeMap(w, segment.Documents); err != nil {
+		return err
+	}
+	if err := writeMap(w, segment.Words); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func writeMap(w *bufio.Writer, m map[string][]int64) error {
+	var buf [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(buf[:], uint64(len(m)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for key, vector := range m {
+		if err := writeString(w, key); err != nil {
+			return err
+		}
+		if err := encodeVector(w, vector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeString(w *bufio.Writer, s string) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(s)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+	return err
+}
+
+// loadSegment reads back a segment written by writeSegment
+func loadSegment(path string) (*Vectors, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	segment := NewVectors()
+
+	documents, err := readMap(r)
+	if err != nil {
+		return nil, err
+	}
+	segment.Documents = documents
+
+	words, err := readMap(r)
+	if err != nil {
+		return nil, err
+	}
+	segment.Words = words
+
+	return segment, nil
+}
+
+func readMap(r *bufio.Reader) (map[string][]int64, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]int64, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		vector, err := decodeVector(r)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = vector
+	}
+	return m, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// sparseThreshold is the fraction of nonzero elements below which a
+// vector is encoded sparsely rather than as raw varints
+const sparseThreshold = 0.25
+
+// encodeVector writes v as either a dense run of varints or, when mostly
+// zero, a sparse list of varint-delta indices paired with values
+func encodeVector(w *bufio.Writer, v []int64) error {
+	nonzero := 0
+	for _, element := range v {
+		if element != 0 {
+			nonzero++
+		}
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	if float64(nonzero) >= sparseThreshold*float64(len(v)) {
+		if err := w.WriteByte(0); err != nil {
+			return err
+		}
+		n := binary.PutUvarint(buf[:], uint64(len(v)))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		for _, element := range v {
+			n := binary.PutVarint(buf[:], element)
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := w.WriteByte(1); err != nil {
+		return err
+	}
+	n := binary.PutUvarint(buf[:], uint64(len(v)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	n = binary.PutUvarint(buf[:], uint64(nonzero))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	previous := 0
+	for i, element := range v {
+		if element == 0 {
+			continue
+		}
+		n := binary.PutUvarint(buf[:], uint64(i-previous))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		n = binary.PutVarint(buf[:], element)
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		previous = i
+	}
+	return nil
+}
+
+// decodeVector reads back a vector written by encodeVector
+func decodeVector(r *bufio.Reader) ([]int64, error) {
+	flag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	v := make([]int64, size)
+
+	if flag == 0 {
+		for i := range v {
+			value, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+		return v, nil
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	index := 0
+	for c := uint64(0); c < count; c++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		index += int(delta)
+		value, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		v[index] = value
+	}
+	return v, nil
+}
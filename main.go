@@ -7,14 +7,12 @@ package main
 import (
 	"bufio"
 	"compress/bzip2"
-	"encoding/xml"
 	"flag"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"log"
 	"math"
-	"math/rand"
 	"os"
 	"sort"
 	"unicode"
@@ -55,17 +53,24 @@ var authors = map[string]string{
 }
 
 var (
-	demoMode = flag.Bool("demo", false, "demo mode")
+	demoMode    = flag.Bool("demo", false, "demo mode")
+	wikiInput   = flag.String("input", "enwiki-latest-pages-articles.xml.bz2", "path to the wikipedia articles dump (bzip2 compressed xml)")
+	wikiWorkers = flag.Int("workers", 8, "number of worker goroutines vectorizing articles")
+	wikiLimit   = flag.Int("limit", 0, "maximum number of articles to process, 0 for unlimited")
 )
 
 type Vectors struct {
 	Documents, Words map[string][]int64
+	// Sources holds the last text each document was processed with, so
+	// UpdateDocument can diff against it
+	Sources map[string][]byte
 }
 
 func NewVectors() *Vectors {
 	return &Vectors{
 		Documents: make(map[string][]int64),
 		Words:     make(map[string][]int64),
+		Sources:   make(map[string][]byte),
 	}
 }
 
@@ -125,6 +130,9 @@ type BigVector struct {
 	Words map[string][]int64
 	// Name the name of this document vector
 	Name string
+	// Weighting is the scheme used to scale bigram contributions into
+	// Words; ProcessStream always produces WeightRaw
+	Weighting Weighting
 }
 
 // NewBigVector creates a new big vector
@@ -158,31 +166,7 @@ func ProcessFile(name string, done chan *BigVector) {
 func ProcessStream(in io.Reader, name string, done chan *BigVector) {
 	b := NewBigVector(vectorSize)
 
-	vector, cache, reader, word, buffer, size :=
-		b.Vector, make(map[uint64][]int8), bufio.NewReader(in), "", NewCircularBuffer(), len(b.Vector)
-
-	// lookup a cached transform
-	lookup := func(a string) []int8 {
-		h := hash(a)
-		transform, found := cache[h]
-		if found {
-			return transform
-		}
-		transform = make([]int8, size)
-		rnd := rand.New(rand.NewSource(int64(h)))
-		for i := range vector {
-			// https://en.wikipedia.org/wiki/Random_projection#More_computationally_efficient_random_projections
-			// make below distribution function of vector element index
-			switch rnd.Intn(6) {
-			case 0:
-				transform[i] = 1
-			case 1:
-				transform[i] = -1
-			}
-		}
-		cache[h] = transform
-		return transform
-	}
+	lookup, reader, word, buffer := newLookup(vectorSize), bufio.NewReader(in), "", NewCircularBuffer()
 
 	for {
 		r, _, err := reader.ReadRune()
@@ -192,45 +176,7 @@ func ProcessStream(in io.Reader, name string, done chan *BigVector) {
 		if unicode.IsLetter(r) || r == '\'' {
 			word += string(unicode.ToLower(r))
 		} else if word != "" {
-			// compute the order 1 markov model document vector
-			transform := lookup(buffer.GetPrevious() + word)
-			for i, t := range transform {
-				vector[i] += int64(t)
-			}
-
-			// find the word vector for the current word
-			center := buffer.Item(bufferSize / 2)
-			wordVector := b.Words[center]
-			if wordVector == nil {
-				wordVector = make([]int64, size)
-				b.Words[center] = wordVector
-			}
-
-			// compute the word vector
-			/*for i := 0; i < bufferSize; i++ {
-				current := buffer.Lookup(i)
-				if current == center {
-					continue
-				}
-				transform := lookup(current)
-				for i, t := range transform {
-					wordVector[i] += int64(t)
-				}
-			}*/
-
-			// compute the order 1 markov model word vector
-			last := buffer.Item(0)
-			for i := 1; i < bufferSize; i++ {
-				current := buffer.Item(i)
-				if current == center {
-					continue
-				}
-				transform := lookup(last + current)
-				for i, t := range transform {
-					wordVector[i] += int64(t)
-				}
-				last = current
-			}
+			accumulateBigram(buffer, word, lookup, b.Vector, b.Words, rawWeight)
 
 			buffer.Push(word)
 			word = ""
@@ -241,6 +187,47 @@ func ProcessStream(in io.Reader, name string, done chan *BigVector) {
 	done <- b
 }
 
+// rawWeight is the weight function ProcessStream always uses: every
+// bigram contributes its unweighted +-1 random projection
+func rawWeight(center, neighbor string) float64 {
+	return 1
+}
+
+// accumulateBigram performs the order 1 markov model update ProcessStream's
+// main loop runs for a single completed word: the document vector gets the
+// buffer's previous word paired with word, and the window's center word's
+// vector gets every other neighbor in buffer, each scaled by weight(center,
+// neighbor). A zero weight skips that neighbor's projection entirely, which
+// is how ProcessStreamWeighted turns this into a PPMI-weighted update.
+func accumulateBigram(buffer *CircularBuffer, word string, lookup func(string) []int8, docVector []int64, wordVectors map[string][]int64, weight func(center, neighbor string) float64) {
+	transform := lookup(buffer.GetPrevious() + word)
+	for i, t := range transform {
+		docVector[i] += int64(t)
+	}
+
+	center := buffer.Item(bufferSize / 2)
+	wordVector := wordVectors[center]
+	if wordVector == nil {
+		wordVector = make([]int64, len(docVector))
+		wordVectors[center] = wordVector
+	}
+
+	last := buffer.Item(0)
+	for i := 1; i < bufferSize; i++ {
+		current := buffer.Item(i)
+		if current == center {
+			continue
+		}
+		if w := weight(center, current); w != 0 {
+			transform := lookup(last + current)
+			for i, t := range transform {
+				wordVector[i] += int64(math.Round(w * float64(t)))
+			}
+		}
+		last = current
+	}
+}
+
 // Distance computes the distance between two document vectors
 func (b *BigVector) Distance(a *BigVector) float64 {
 	/*var d int64
@@ -261,6 +248,9 @@ func Similarity(a, b []int64) float64 {
 		xx += x * x
 		yy += y * y
 	}
+	if xx == 0 || yy == 0 {
+		return 0
+	}
 	return dot / math.Sqrt(xx*yy)
 }
 
@@ -375,39 +365,13 @@ func main() {
 		return
 	}
 
-	file, err := os.Open("enwiki-latest-pages-articles.xml.bz2")
+	file, err := os.Open(*wikiInput)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer file.Close()
 
-	decoder := xml.NewDecoder(bzip2.NewReader(file))
-	decoder.Strict = false
-	inText, inTitle, title, article, currentTitle := false, false, "", "", ""
-	for token, err := decoder.RawToken(); err == nil; token, err = decoder.RawToken() {
-		switch t := token.(type) {
-		case xml.StartElement:
-			if t.Name.Local == "text" {
-				inText = true
-			} else if t.Name.Local == "title" {
-				inTitle = true
-			}
-		case xml.CharData:
-			if inText {
-				article += string(t)
-			} else if inTitle {
-				title += string(t)
-			}
-		case xml.EndElement:
-			if inText {
-				//fmt.Printf("inText: %v\n", currentTitle)
-				_ = currentTitle
-				inText, article = false, ""
-			} else if inTitle {
-				currentTitle = title
-				//fmt.Printf("inTitle: %v\n", currentTitle)
-				inTitle, title = false, ""
-			}
-		}
-	}
+	vectors, index := processWikipedia(bzip2.NewReader(file), *wikiWorkers, *wikiLimit)
+	fmt.Printf("processed %d documents, %d words, %d indexed terms\n",
+		len(vectors.Documents), len(vectors.Words), len(index.inverted))
 }
@@ -0,0 +1,430 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "sort"
+
+// arrayMaxCardinality is the container size above which an array
+// container is converted to a bitmap container
+const arrayMaxCardinality = 4096
+
+// bitmapWords is the number of uint64 words needed to address every
+// 16-bit low value, i.e. 1024*64 = 65536 bits
+const bitmapWords = 1024
+
+type containerType int
+
+const (
+	containerArray containerType = iota
+	containerBitmap
+	containerRun
+)
+
+// runInterval is a contiguous run [Start, Start+Length] of set values
+type runInterval struct {
+	Start, Length uint16
+}
+
+// container holds the low 16 bits of a chunk of a Bitmap, in whichever
+// of the three roaring representations currently fits best
+type container struct {
+	typ    containerType
+	array  []uint16      // sorted, unique; containerArray
+	bitmap []uint64      // len bitmapWords; containerBitmap
+	runs   []runInterval // sorted, non-overlapping; containerRun
+}
+
+func newArrayContainer() *container {
+	return &container{typ: containerArray}
+}
+
+func newContainerFromBitmap(words []uint64) *container {
+	c := &container{typ: containerBitmap, bitmap: words}
+	if c.cardinality() <= arrayMaxCardinality {
+		c.array = c.toArraySlice()
+		c.typ, c.bitmap = containerArray, nil
+	}
+	return c
+}
+
+func (c *container) add(x uint16) {
+	switch c.typ {
+	case containerArray:
+		i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+		if i < len(c.array) && c.array[i] == x {
+			return
+		}
+		c.array = append(c.array, 0)
+		copy(c.array[i+1:], c.array[i:])
+		c.array[i] = x
+		if len(c.array) > arrayMaxCardinality {
+			c.bitmap = c.toBitmapWords()
+			c.typ, c.array = containerBitmap, nil
+		}
+	case containerBitmap:
+		c.bitmap[x/64] |= 1 << (x % 64)
+	case containerRun:
+		*c = *newContainerFromBitmap(c.toBitmapWords())
+		c.add(x)
+	}
+}
+
+func (c *container) contains(x uint16) bool {
+	switch c.typ {
+	case containerArray:
+		i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+		return i < len(c.array) && c.array[i] == x
+	case containerBitmap:
+		return c.bitmap[x/64]&(1<<(x%64)) != 0
+	case containerRun:
+		for _, run := range c.runs {
+			if x >= run.Start && x <= run.Start+run.Length {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (c *container) cardinality() int {
+	switch c.typ {
+	case containerArray:
+		return len(c.array)
+	case containerBitmap:
+		n := 0
+		for _, word := range c.bitmap {
+			n += popcount(word)
+		}
+		return n
+	case containerRun:
+		n := 0
+		for _, run := range c.runs {
+			n += int(run.Length) + 1
+		}
+		return n
+	}
+	return 0
+}
+
+func popcount(x uint64) int {
+	n := 0
+	for x != 0 {
+		x &= x - 1
+		n++
+	}
+	return n
+}
+
+// toArraySlice expands any container into a sorted slice of its values
+func (c *container) toArraySlice() []uint16 {
+	switch c.typ {
+	case containerArray:
+		return c.array
+	case containerBitmap:
+		values := make([]uint16, 0, c.cardinality())
+		for i, word := range c.bitmap {
+			for word != 0 {
+				bit := word & -word
+				values = append(values, uint16(i*64+popcount(bit-1)))
+				word &= word - 1
+			}
+		}
+		return values
+	case containerRun:
+		values := make([]uint16, 0, c.cardinality())
+		for _, run := range c.runs {
+			for v := run.Start; ; v++ {
+				values = append(values, v)
+				if v == run.Start+run.Length {
+					break
+				}
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// toBitmapWords expands any container into a full 1024-word bitmap
+func (c *container) toBitmapWords() []uint64 {
+	if c.typ == containerBitmap {
+		return c.bitmap
+	}
+	words := make([]uint64, bitmapWords)
+	for _, v := range c.toArraySlice() {
+		words[v/64] |= 1 << (v % 64)
+	}
+	return words
+}
+
+// runOptimize returns a run-length encoded copy of c when doing so is
+// smaller than its current representation, and c unchanged otherwise
+func (c *container) runOptimize() *container {
+	values := c.toArraySlice()
+	if len(values) == 0 {
+		return c
+	}
+
+	var runs []runInterval
+	start, length := values[0], uint16(0)
+	for i := 1; i < len(values); i++ {
+		if values[i] == values[i-1]+1 {
+			length++
+			continue
+		}
+		runs = append(runs, runInterval{Start: start, Length: length})
+		start, length = values[i], 0
+	}
+	runs = append(runs, runInterval{Start: start, Length: length})
+
+	// a run costs two uint16s, an array element costs one, and a bitmap
+	// costs bitmapWords*4 uint16s whatever its cardinality
+	current := len(values)
+	if c.typ == containerBitmap {
+		current = bitmapWords * 4
+	}
+	if len(runs)*2 < current {
+		return &container{typ: containerRun, runs: runs}
+	}
+	return c
+}
+
+func containerAnd(a, b *container) *container {
+	if a.typ == containerArray && b.typ == containerArray {
+		return &container{typ: containerArray, array: sortedIntersect(a.array, b.array)}
+	}
+	aw, bw := a.toBitmapWords(), b.toBitmapWords()
+	result := make([]uint64, bitmapWords)
+	for i := range result {
+		result[i] = aw[i] & bw[i]
+	}
+	return newContainerFromBitmap(result)
+}
+
+func containerOr(a, b *container) *container {
+	if a.typ == containerArray && b.typ == containerArray {
+		return &container{typ: containerArray, array: sortedUnion(a.array, b.array)}
+	}
+	aw, bw := a.toBitmapWords(), b.toBitmapWords()
+	result := make([]uint64, bitmapWords)
+	for i := range result {
+		result[i] = aw[i] | bw[i]
+	}
+	return newContainerFromBitmap(result)
+}
+
+func containerAndNot(a, b *container) *container {
+	if a.typ == containerArray && b.typ == containerArray {
+		return &container{typ: containerArray, array: sortedDifference(a.array, b.array)}
+	}
+	aw, bw := a.toBitmapWords(), b.toBitmapWords()
+	result := make([]uint64, bitmapWords)
+	for i := range result {
+		result[i] = aw[i] &^ bw[i]
+	}
+	return newContainerFromBitmap(result)
+}
+
+func sortedIntersect(a, b []uint16) []uint16 {
+	var result []uint16
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+func sortedUnion(a, b []uint16) []uint16 {
+	result := make([]uint16, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		default:
+			result = append(result, b[j])
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	result = append(result, b[j:]...)
+	return result
+}
+
+func sortedDifference(a, b []uint16) []uint16 {
+	var result []uint16
+	i, j := 0, 0
+	for i < len(a) {
+		if j >= len(b) || a[i] < b[j] {
+			result = append(result, a[i])
+			i++
+		} else if a[i] == b[j] {
+			i++
+			j++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// Bitmap is a roaring bitmap over uint32 values: each value is split into
+// a 16-bit high key selecting a container and a 16-bit low value stored
+// within it
+type Bitmap struct {
+	keys       []uint32
+	containers []*container
+}
+
+// NewBitmap creates an empty Bitmap
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+func (b *Bitmap) find(key uint32) int {
+	return sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= key })
+}
+
+// Add sets x in the bitmap
+func (b *Bitmap) Add(x uint32) {
+	key, low := x>>16, uint16(x)
+	i := b.find(key)
+	if i < len(b.keys) && b.keys[i] == key {
+		b.containers[i].add(low)
+		return
+	}
+	b.keys = append(b.keys, 0)
+	copy(b.keys[i+1:], b.keys[i:])
+	b.keys[i] = key
+
+	c := newArrayContainer()
+	c.add(low)
+	b.containers = append(b.containers, nil)
+	copy(b.containers[i+1:], b.containers[i:])
+	b.containers[i] = c
+}
+
+// Contains reports whether x is set in the bitmap
+func (b *Bitmap) Contains(x uint32) bool {
+	key, low := x>>16, uint16(x)
+	i := b.find(key)
+	return i < len(b.keys) && b.keys[i] == key && b.containers[i].contains(low)
+}
+
+// Cardinality is the number of values set in the bitmap
+func (b *Bitmap) Cardinality() int {
+	n := 0
+	for _, c := range b.containers {
+		n += c.cardinality()
+	}
+	return n
+}
+
+// Iterate calls fn with every value in the bitmap in ascending order,
+// stopping early if fn returns false
+func (b *Bitmap) Iterate(fn func(uint32) bool) {
+	for i, key := range b.keys {
+		for _, low := range b.containers[i].toArraySlice() {
+			if !fn(key<<16 | uint32(low)) {
+				return
+			}
+		}
+	}
+}
+
+// RunOptimize converts each container to a run-length encoding when that
+// representation is smaller
+func (b *Bitmap) RunOptimize() {
+	for i, c := range b.containers {
+		b.containers[i] = c.runOptimize()
+	}
+}
+
+// And returns the intersection of b and other
+func (b *Bitmap) And(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	i, j := 0, 0
+	for i < len(b.keys) && j < len(other.keys) {
+		switch {
+		case b.keys[i] == other.keys[j]:
+			c := containerAnd(b.containers[i], other.containers[j])
+			if c.cardinality() > 0 {
+				result.keys = append(result.keys, b.keys[i])
+				result.containers = append(result.containers, c)
+			}
+			i++
+			j++
+		case b.keys[i] < other.keys[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+// Or returns the union of b and other
+func (b *Bitmap) Or(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	i, j := 0, 0
+	for i < len(b.keys) || j < len(other.keys) {
+		switch {
+		case j >= len(other.keys) || (i < len(b.keys) && b.keys[i] < other.keys[j]):
+			result.keys = append(result.keys, b.keys[i])
+			result.containers = append(result.containers, b.containers[i])
+			i++
+		case i >= len(b.keys) || b.keys[i] > other.keys[j]:
+			result.keys = append(result.keys, other.keys[j])
+			result.containers = append(result.containers, other.containers[j])
+			j++
+		default:
+			result.keys = append(result.keys, b.keys[i])
+			result.containers = append(result.containers, containerOr(b.containers[i], other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// AndNot returns the values of b that are not present in other
+func (b *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	i, j := 0, 0
+	for i < len(b.keys) {
+		switch {
+		case j >= len(other.keys) || b.keys[i] < other.keys[j]:
+			result.keys = append(result.keys, b.keys[i])
+			result.containers = append(result.containers, b.containers[i])
+			i++
+		case b.keys[i] > other.keys[j]:
+			j++
+		default:
+			c := containerAndNot(b.containers[i], other.containers[j])
+			if c.cardinality() > 0 {
+				result.keys = append(result.keys, b.keys[i])
+				result.containers = append(result.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+	return result
+}
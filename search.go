@@ -0,0 +1,213 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"unicode"
+)
+
+// titleBoost is how much more a title hit counts than a body hit when
+// scoring TF-IDF
+const titleBoost = 3.0
+
+// termStats is how often a term occurs in one document, and whether it
+// was found in the title
+type termStats struct {
+	Freq    int
+	InTitle bool
+}
+
+// postingEntry is one row of an inverted index posting list
+type postingEntry struct {
+	DocID   int
+	Freq    int
+	InTitle bool
+}
+
+// TextIndex is a classic forward+inverted index built alongside the dense
+// BigVector index, giving exact-term recall that random-projection
+// vectors alone can't provide
+type TextIndex struct {
+	docNames []string
+	docIndex map[string]int
+	forward  []map[string]*termStats
+	inverted map[string][]postingEntry
+	// docSets is a roaring bitmap of docIDs per term, used for fast
+	// multi-term boolean queries alongside the ranked TF-IDF postings
+	docSets map[string]*Bitmap
+}
+
+// NewTextIndex creates an empty TextIndex
+func NewTextIndex() *TextIndex {
+	return &TextIndex{
+		docIndex: make(map[string]int),
+		inverted: make(map[string][]postingEntry),
+		docSets:  make(map[string]*Bitmap),
+	}
+}
+
+// tokenizeWords splits s into the same lowercased letter/apostrophe
+// tokens ProcessStream uses, except that a final word with no trailing
+// delimiter is kept rather than dropped
+func tokenizeWords(s string) []string {
+	var words []string
+	word := ""
+	for _, r := range s {
+		if unicode.IsLetter(r) || r == '\'' {
+			word += string(unicode.ToLower(r))
+		} else if word != "" {
+			words = append(words, word)
+			word = ""
+		}
+	}
+	if word != "" {
+		words = append(words, word)
+	}
+	return words
+}
+
+// AddDocument indexes title and body text under name, recording in each
+// posting list whether the term was found in the title
+func (ti *TextIndex) AddDocument(name, title, body string) int {
+	docID := len(ti.docNames)
+	ti.docNames = append(ti.docNames, name)
+	ti.docIndex[name] = docID
+
+	stats := make(map[string]*termStats)
+	for _, word := range tokenizeWords(title) {
+		s := stats[word]
+		if s == nil {
+			s = &termStats{}
+			stats[word] = s
+		}
+		s.Freq++
+		s.InTitle = true
+	}
+	for _, word := range tokenizeWords(body) {
+		s := stats[word]
+		if s == nil {
+			s = &termStats{}
+			stats[word] = s
+		}
+		s.Freq++
+	}
+
+	ti.forward = append(ti.forward, stats)
+	for term, s := range stats {
+		ti.inverted[term] = append(ti.inverted[term], postingEntry{
+			DocID:   docID,
+			Freq:    s.Freq,
+			InTitle: s.InTitle,
+		})
+
+		set := ti.docSets[term]
+		if set == nil {
+			set = NewBitmap()
+			ti.docSets[term] = set
+		}
+		set.Add(uint32(docID))
+	}
+	return docID
+}
+
+// BooleanAnd returns the names of every document containing all of terms,
+// evaluated as a roaring bitmap intersection across their posting sets
+func (ti *TextIndex) BooleanAnd(terms []string) []string {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	result := ti.docSets[terms[0]]
+	if result == nil {
+		return nil
+	}
+	for _, term := range terms[1:] {
+		set := ti.docSets[term]
+		if set == nil {
+			return nil
+		}
+		result = result.And(set)
+	}
+
+	var names []string
+	result.Iterate(func(docID uint32) bool {
+		names = append(names, ti.docNames[docID])
+		return true
+	})
+	return names
+}
+
+// scoreTFIDF accumulates a TF-IDF score per docID for the given query
+// terms, boosting title hits
+func (ti *TextIndex) scoreTFIDF(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	n := float64(len(ti.docNames))
+	for _, term := range terms {
+		postings := ti.inverted[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(n/float64(len(postings)) + 1)
+		for _, p := range postings {
+			weight := 1.0
+			if p.InTitle {
+				weight = titleBoost
+			}
+			scores[p.DocID] += idf * float64(p.Freq) * weight
+		}
+	}
+	return scores
+}
+
+// topK turns a docID -> score map into a sorted, optionally truncated
+// Distances slice
+func (ti *TextIndex) topK(scores map[int]float64, k int) Distances {
+	distances := make(Distances, 0, len(scores))
+	for docID, score := range scores {
+		distances = append(distances, Distance{D: score, Name: ti.docNames[docID]})
+	}
+	sort.Sort(distances)
+	if k > 0 && k < len(distances) {
+		distances = distances[:k]
+	}
+	return distances
+}
+
+// Search ranks documents by TF-IDF against query, with title hits
+// weighted higher than body hits
+func (ti *TextIndex) Search(query string, k int) Distances {
+	return ti.topK(ti.scoreTFIDF(tokenizeWords(query)), k)
+}
+
+// HybridSearch blends the TF-IDF score with cosine similarity against the
+// dense document vectors. alpha is the weight given to the vector
+// similarity, from 0 (pure TF-IDF) to 1 (pure vector similarity)
+func (ti *TextIndex) HybridSearch(query string, k int, vectors *Vectors, alpha float64) Distances {
+	terms := tokenizeWords(query)
+	scores := ti.scoreTFIDF(terms)
+
+	queryVector := make([]int64, vectorSize)
+	for _, term := range terms {
+		for i, element := range vectors.Words[term] {
+			queryVector[i] += element
+		}
+	}
+
+	combined := make(map[int]float64, len(scores))
+	for docID, score := range scores {
+		combined[docID] = (1 - alpha) * score
+	}
+	for docID, name := range ti.docNames {
+		docVector := vectors.Documents[name]
+		if docVector == nil {
+			continue
+		}
+		combined[docID] += alpha * Similarity(queryVector, docVector)
+	}
+
+	return ti.topK(combined, k)
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"unicode"
+)
+
+// MergeWithSource is Merge plus recording vector's source text, so a later
+// UpdateDocument call has something to diff against
+func (v *Vectors) MergeWithSource(vector *BigVector, source []byte) {
+	v.Merge(vector)
+	if v.Sources == nil {
+		v.Sources = make(map[string][]byte)
+	}
+	v.Sources[vector.Name] = append([]byte(nil), source...)
+}
+
+// UpdateDocument re-indexes name given its new text, diffing it against
+// the text it was last processed with and only recomputing the bigrams in
+// and around the changed region. Because each bigram's random projection
+// is deterministic (seeded by fnv hash), subtracting the old contribution
+// and adding the new one is exact, so the random-projection work of a
+// re-index is O(diff size) instead of O(document size). Both texts are
+// still tokenized and compared in full to find the changed region.
+//
+// This handles the common case of a single edited region cleanly; several
+// disjoint edits still produce a correct result, just one that covers the
+// whole span from the first change to the last rather than each edit
+// individually.
+//
+// name must either be new to v or have been indexed through Merge/
+// UpdateDocument with its source tracked (e.g. via MergeWithSource);
+// UpdateDocument panics rather than silently double-counting a document
+// whose prior contribution it can't subtract out.
+func (v *Vectors) UpdateDocument(name string, newText []byte) {
+	if v.Sources == nil {
+		v.Sources = make(map[string][]byte)
+	}
+
+	oldText, known := v.Sources[name]
+	if !known {
+		if _, exists := v.Documents[name]; exists {
+			panic("bigvector: UpdateDocument called on " + name + " with no tracked source; index it via MergeWithSource first")
+		}
+		done := make(chan *BigVector, 1)
+		ProcessStream(bytes.NewReader(newText), name, done)
+		v.Merge(<-done)
+		v.Sources[name] = append([]byte(nil), newText...)
+		return
+	}
+
+	oldWords := processStreamWords(oldText)
+	newWords := processStreamWords(newText)
+
+	prefix := commonPrefixLen(oldWords, newWords)
+	suffix := commonSuffixLen(oldWords[prefix:], newWords[prefix:])
+
+	if prefix == len(oldWords) && prefix == len(newWords) {
+		v.Sources[name] = append([]byte(nil), newText...)
+		return
+	}
+
+	// a word up to bufferSize words before or after the edit can still have
+	// the edit inside its bufferSize-wide neighbor window, so replay covers
+	// that too
+	context := prefix - bufferSize
+	if context < 0 {
+		context = 0
+	}
+	oldEnd := len(oldWords) - suffix + bufferSize
+	if oldEnd > len(oldWords) {
+		oldEnd = len(oldWords)
+	}
+	newEnd := len(newWords) - suffix + bufferSize
+	if newEnd > len(newWords) {
+		newEnd = len(newWords)
+	}
+
+	lookup := newLookup(vectorSize)
+	oldVector, oldWordVectors := replayWords(oldWords[context:oldEnd], prefix-context, lookup)
+	newVector, newWordVectors := replayWords(newWords[context:newEnd], prefix-context, lookup)
+
+	docVector := v.Documents[name]
+	if docVector == nil {
+		docVector = make([]int64, vectorSize)
+		v.Documents[name] = docVector
+	}
+	for i := range docVector {
+		docVector[i] += newVector[i] - oldVector[i]
+	}
+
+	for word, vector := range oldWordVectors {
+		wordVector := v.Words[word]
+		if wordVector == nil {
+			continue
+		}
+		for i, element := range vector {
+			wordVector[i] -= element
+		}
+		if isZeroVector(wordVector) {
+			delete(v.Words, word)
+		}
+	}
+	for word, vector := range newWordVectors {
+		wordVector := v.Words[word]
+		if wordVector == nil {
+			wordVector = make([]int64, vectorSize)
+			v.Words[word] = wordVector
+		}
+		for i, element := range vector {
+			wordVector[i] += element
+		}
+		if isZeroVector(wordVector) {
+			delete(v.Words, word)
+		}
+	}
+
+	v.Sources[name] = append([]byte(nil), newText...)
+}
+
+// replayWords runs the same buffer-driven bigram accumulation ProcessStream
+// does, over an already-tokenized word slice. The first skip words only
+// prime the circular buffer's context and contribute nothing, letting a
+// caller replay a window that starts mid-document
+func replayWords(words []string, skip int, lookup func(string) []int8) ([]int64, map[string][]int64) {
+	docVector := make([]int64, vectorSize)
+	wordVectors := make(map[string][]int64)
+	buffer := NewCircularBuffer()
+
+	for idx, word := range words {
+		if idx >= skip {
+			accumulateBigram(buffer, word, lookup, docVector, wordVectors, rawWeight)
+		}
+		buffer.Push(word)
+	}
+
+	return docVector, wordVectors
+}
+
+// processStreamWords tokenizes text exactly as ProcessStream's rune scan
+// does, including silently dropping a final word with no trailing
+// delimiter, so a diff never touches a word the original indexing pass
+// never saw
+func processStreamWords(text []byte) []string {
+	var words []string
+	word := ""
+	for _, r := range string(text) {
+		if unicode.IsLetter(r) || r == '\'' {
+			word += string(unicode.ToLower(r))
+		} else if word != "" {
+			words = append(words, word)
+			word = ""
+		}
+	}
+	return words
+}
+
+// isZeroVector reports whether every component of vector is zero, meaning
+// the word it belongs to no longer occurs anywhere v has indexed and its
+// entry should be dropped rather than left behind as a zombie
+func isZeroVector(vector []int64) bool {
+	for _, element := range vector {
+		if element != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func commonPrefixLen(a, b []string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func commonSuffixLen(a, b []string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+	return i
+}
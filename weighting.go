@@ -0,0 +1,189 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"unicode"
+)
+
+// Weighting selects how bigram contributions are scaled into a word
+// vector
+type Weighting int
+
+const (
+	// WeightRaw adds the unweighted +-1 random projection, as ProcessStream
+	// always has
+	WeightRaw Weighting = iota
+	// WeightPPMI scales the projection by a positive pointwise mutual
+	// information weight with no shift (k = 1)
+	WeightPPMI
+	// WeightSPPMI is WeightPPMI with a configurable log(k) shift, letting
+	// callers push weights further towards zero
+	WeightSPPMI
+)
+
+// defaultSPPMIShift is the k used by WeightPPMI, equivalent to WeightSPPMI
+// with no extra shift
+const defaultSPPMIShift = 1
+
+// pairCounts holds the unigram and co-occurrence counts gathered in the
+// first pass of ProcessStreamWeighted
+type pairCounts struct {
+	words map[string]int64
+	pairs map[string]int64
+	total int64
+}
+
+func pairKey(w, c string) string {
+	return w + "\x00" + c
+}
+
+// collectPairCounts runs the bufferSize sliding window over in, counting
+// how often each word appears and how often each (center, neighbor) pair
+// co-occurs, without building any vectors
+func collectPairCounts(in io.Reader) *pairCounts {
+	counts := &pairCounts{
+		words: make(map[string]int64),
+		pairs: make(map[string]int64),
+	}
+
+	reader, buffer, word := bufio.NewReader(in), NewCircularBuffer(), ""
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			break
+		}
+		if unicode.IsLetter(r) || r == '\'' {
+			word += string(unicode.ToLower(r))
+			continue
+		}
+		if word == "" {
+			continue
+		}
+
+		counts.words[word]++
+		counts.total++
+
+		center := buffer.Item(bufferSize / 2)
+		if center != "" {
+			for i := 0; i < bufferSize; i++ {
+				neighbor := buffer.Item(i)
+				if neighbor == "" || neighbor == center {
+					continue
+				}
+				counts.pairs[pairKey(center, neighbor)]++
+			}
+		}
+
+		buffer.Push(word)
+		word = ""
+	}
+
+	return counts
+}
+
+// ppmiWeight computes max(0, log(#(w,c)*N/(#w*#c)) - log(k)), the shifted
+// positive PMI weight LexVec uses in place of a raw +-1 count
+func ppmiWeight(counts *pairCounts, w, c string, k float64) float64 {
+	pair := counts.pairs[pairKey(w, c)]
+	if pair == 0 {
+		return 0
+	}
+	wc, cc := counts.words[w], counts.words[c]
+	if wc == 0 || cc == 0 {
+		return 0
+	}
+
+	pmi := math.Log(float64(pair)*float64(counts.total)/(float64(wc)*float64(cc))) - math.Log(k)
+	if pmi < 0 {
+		return 0
+	}
+	return pmi
+}
+
+// ProcessStreamWeighted is a two-pass variant of ProcessStream: the first
+// pass collects unigram and co-occurrence counts, the second pass scales
+// each neighbor bigram's random projection into the word vectors by a PPMI
+// weight (derived from those counts) instead of adding it unweighted. The
+// document vector is still accumulated unweighted
+func ProcessStreamWeighted(in io.Reader, name string, weighting Weighting, k float64, done chan *BigVector) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		panic(err)
+	}
+
+	if weighting == WeightRaw {
+		ProcessStream(bytes.NewReader(data), name, done)
+		return
+	}
+	if weighting == WeightPPMI {
+		k = defaultSPPMIShift
+	} else if k <= 0 {
+		k = defaultSPPMIShift
+	}
+
+	counts := collectPairCounts(bytes.NewReader(data))
+
+	b := NewBigVector(vectorSize)
+	b.Weighting = weighting
+
+	lookup, reader, word, buffer := newLookup(vectorSize), bufio.NewReader(bytes.NewReader(data)), "", NewCircularBuffer()
+
+	weight := func(center, neighbor string) float64 {
+		return ppmiWeight(counts, center, neighbor, k)
+	}
+
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			break
+		}
+		if unicode.IsLetter(r) || r == '\'' {
+			word += string(unicode.ToLower(r))
+			continue
+		}
+		if word == "" {
+			continue
+		}
+
+		accumulateBigram(buffer, word, lookup, b.Vector, b.Words, weight)
+
+		buffer.Push(word)
+		word = ""
+	}
+
+	b.Name = name
+	done <- b
+}
+
+// newLookup returns a cached signed-random-projection transform, the one
+// ProcessStream, ProcessStreamWeighted and UpdateDocument all share
+func newLookup(size int) func(string) []int8 {
+	cache := make(map[uint64][]int8)
+	return func(a string) []int8 {
+		h := hash(a)
+		transform, found := cache[h]
+		if found {
+			return transform
+		}
+		transform = make([]int8, size)
+		rnd := rand.New(rand.NewSource(int64(h)))
+		for i := range transform {
+			switch rnd.Intn(6) {
+			case 0:
+				transform[i] = 1
+			case 1:
+				transform[i] = -1
+			}
+		}
+		cache[h] = transform
+		return transform
+	}
+}
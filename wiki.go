@@ -0,0 +1,109 @@
+// Copyright 2017 The BigVector Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"sync"
+)
+
+// wikiArticle is a single <page> extracted from the wikipedia dump
+type wikiArticle struct {
+	Title, Text string
+}
+
+// processedArticle pairs a vectorized article with its source title and
+// text, so the merge loop can track it for later Vectors.UpdateDocument
+// calls as the dump is re-crawled and can feed the same article into the
+// TextIndex
+type processedArticle struct {
+	vector *BigVector
+	title  string
+	text   string
+}
+
+// processWikipedia streams a wikipedia articles dump, vectorizing each
+// article with a bounded pool of workers as it is decoded rather than
+// reading the whole dump up front. Each article's text is kept in the
+// returned Vectors' Sources for later UpdateDocument calls, so memory use
+// grows with the total text processed. It builds the forward/inverted
+// TextIndex in the same pass, alongside the dense BigVector index
+func processWikipedia(r io.Reader, workers, limit int) (*Vectors, *TextIndex) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	vectors := NewVectors()
+	index := NewTextIndex()
+	articles := make(chan wikiArticle, workers)
+	done := make(chan processedArticle, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for a := range articles {
+				single := make(chan *BigVector, 1)
+				ProcessStream(strings.NewReader(a.Text), a.Title, single)
+				done <- processedArticle{vector: <-single, title: a.Title, text: a.Text}
+			}
+		}()
+	}
+
+	go func() {
+		decoder := xml.NewDecoder(r)
+		decoder.Strict = false
+		inText, inTitle, title, article, currentTitle, count :=
+			false, false, "", "", "", 0
+	loop:
+		for token, err := decoder.RawToken(); err == nil; token, err = decoder.RawToken() {
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "text" {
+					inText = true
+				} else if t.Name.Local == "title" {
+					inTitle = true
+				}
+			case xml.CharData:
+				if inText {
+					article += string(t)
+				} else if inTitle {
+					title += string(t)
+				}
+			case xml.EndElement:
+				if t.Name.Local == "text" {
+					inText = false
+				} else if t.Name.Local == "title" {
+					inTitle, currentTitle, title = false, title, ""
+				} else if t.Name.Local == "page" {
+					if currentTitle != "" && article != "" {
+						articles <- wikiArticle{Title: currentTitle, Text: article}
+						count++
+					}
+					currentTitle, article = "", ""
+					if limit > 0 && count >= limit {
+						break loop
+					}
+				}
+			}
+		}
+		close(articles)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for p := range done {
+		vectors.MergeWithSource(p.vector, []byte(p.text))
+		index.AddDocument(p.title, p.title, p.text)
+	}
+
+	return vectors, index
+}